@@ -0,0 +1,150 @@
+// Package manifestdiff structurally compares two YAML subtrees and reports
+// which leaf paths diverge, so tests that expect two manifest fragments to
+// stay consistent (e.g. sibling instance groups) can assert on a typed
+// result instead of eyeballing a text diff.
+package manifestdiff
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Diff describes the leaf paths that differ between two trees. Added and
+// Removed record keys present on only one side; Changed records keys
+// present on both sides with different values. Unexpected is the subset of
+// Added, Removed and Changed that isn't covered by the allow-list passed to
+// Compare.
+type Diff struct {
+	Added      []string
+	Removed    []string
+	Changed    []string
+	Unexpected []string
+}
+
+// Compare walks before and after structurally, collecting every leaf path
+// that diverges between them. allowedDivergence lists paths (or path
+// suffixes, dot-separated, e.g. "diego.rep.persistent_isolation_segment")
+// that are permitted to differ; any other divergence is surfaced in
+// Unexpected.
+func Compare(before, after interface{}, allowedDivergence []string) *Diff {
+	d := &Diff{}
+	walk(before, after, "", d)
+
+	allowed := make([]string, len(allowedDivergence))
+	copy(allowed, allowedDivergence)
+
+	for _, path := range append(append([]string{}, d.Added...), append(d.Removed, d.Changed...)...) {
+		if !isAllowed(path, allowed) {
+			d.Unexpected = append(d.Unexpected, path)
+		}
+	}
+
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Strings(d.Changed)
+	sort.Strings(d.Unexpected)
+
+	return d
+}
+
+func isAllowed(path string, allowedDivergence []string) bool {
+	for _, allowed := range allowedDivergence {
+		if path == allowed || strings.HasSuffix(path, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+func walk(before, after interface{}, path string, d *Diff) {
+	beforeMap, beforeIsMap := asMap(before)
+	afterMap, afterIsMap := asMap(after)
+	if beforeIsMap && afterIsMap {
+		walkMaps(beforeMap, afterMap, path, d)
+		return
+	}
+
+	beforeList, beforeIsList := before.([]interface{})
+	afterList, afterIsList := after.([]interface{})
+	if beforeIsList && afterIsList {
+		walkLists(beforeList, afterList, path, d)
+		return
+	}
+
+	if !reflect.DeepEqual(before, after) {
+		recordScalarDivergence(before, after, path, d)
+	}
+}
+
+func walkMaps(before, after map[interface{}]interface{}, path string, d *Diff) {
+	for _, key := range unionKeys(before, after) {
+		keyPath := joinPath(path, fmt.Sprintf("%v", key))
+		beforeVal, beforeOK := before[key]
+		afterVal, afterOK := after[key]
+
+		switch {
+		case beforeOK && !afterOK:
+			d.Removed = append(d.Removed, keyPath)
+		case !beforeOK && afterOK:
+			d.Added = append(d.Added, keyPath)
+		default:
+			walk(beforeVal, afterVal, keyPath, d)
+		}
+	}
+}
+
+func walkLists(before, after []interface{}, path string, d *Diff) {
+	if len(before) != len(after) {
+		d.Changed = append(d.Changed, path)
+		return
+	}
+	for i := range before {
+		walk(before[i], after[i], fmt.Sprintf("%s[%d]", path, i), d)
+	}
+}
+
+func recordScalarDivergence(before, after interface{}, path string, d *Diff) {
+	switch {
+	case before == nil:
+		d.Added = append(d.Added, path)
+	case after == nil:
+		d.Removed = append(d.Removed, path)
+	default:
+		d.Changed = append(d.Changed, path)
+	}
+}
+
+func asMap(v interface{}) (map[interface{}]interface{}, bool) {
+	m, ok := v.(map[interface{}]interface{})
+	return m, ok
+}
+
+func unionKeys(a, b map[interface{}]interface{}) []interface{} {
+	seen := make(map[interface{}]bool, len(a)+len(b))
+	keys := make([]interface{}, 0, len(a)+len(b))
+	for k := range a {
+		if !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	for k := range b {
+		if !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprintf("%v", keys[i]) < fmt.Sprintf("%v", keys[j])
+	})
+	return keys
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,74 @@
+package manifestdiff
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompareNoDivergence(t *testing.T) {
+	before := map[interface{}]interface{}{"instances": 1, "name": "rep"}
+	after := map[interface{}]interface{}{"instances": 1, "name": "rep"}
+
+	diff := Compare(before, after, nil)
+
+	if diff.Unexpected != nil {
+		t.Errorf("expected no unexpected divergence, got %v", diff.Unexpected)
+	}
+}
+
+func TestCompareUnexpectedChange(t *testing.T) {
+	before := map[interface{}]interface{}{"instances": 1}
+	after := map[interface{}]interface{}{"instances": 2}
+
+	diff := Compare(before, after, nil)
+
+	if len(diff.Unexpected) != 1 || diff.Unexpected[0] != "instances" {
+		t.Errorf("expected 'instances' to be unexpected, got %v", diff.Unexpected)
+	}
+}
+
+func TestCompareAllowedDivergence(t *testing.T) {
+	before := map[interface{}]interface{}{
+		"diego": map[interface{}]interface{}{
+			"rep": map[interface{}]interface{}{
+				"persistent_isolation_segment": false,
+			},
+		},
+		"placement_tags": []interface{}{},
+	}
+	after := map[interface{}]interface{}{
+		"diego": map[interface{}]interface{}{
+			"rep": map[interface{}]interface{}{
+				"persistent_isolation_segment": true,
+			},
+		},
+		"placement_tags": []interface{}{"iso-seg-1"},
+	}
+
+	diff := Compare(before, after, []string{"placement_tags", "diego.rep.persistent_isolation_segment"})
+
+	if diff.Unexpected != nil {
+		t.Errorf("expected allow-listed paths to produce no unexpected divergence, got %v", diff.Unexpected)
+	}
+	wantChanged := []string{"diego.rep.persistent_isolation_segment", "placement_tags"}
+	if !reflect.DeepEqual(diff.Changed, wantChanged) {
+		t.Errorf("expected %v to be recorded as changed, got %v", wantChanged, diff.Changed)
+	}
+}
+
+func TestCompareAddedAndRemoved(t *testing.T) {
+	before := map[interface{}]interface{}{"old_key": "value"}
+	after := map[interface{}]interface{}{"new_key": "value"}
+
+	diff := Compare(before, after, nil)
+
+	if len(diff.Removed) != 1 || diff.Removed[0] != "old_key" {
+		t.Errorf("expected 'old_key' to be removed, got %v", diff.Removed)
+	}
+	if len(diff.Added) != 1 || diff.Added[0] != "new_key" {
+		t.Errorf("expected 'new_key' to be added, got %v", diff.Added)
+	}
+	if len(diff.Unexpected) != 2 {
+		t.Errorf("expected both changes to be unexpected, got %v", diff.Unexpected)
+	}
+}
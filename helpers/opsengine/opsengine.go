@@ -0,0 +1,421 @@
+// Package opsengine models bosh ops files as typed values and applies them
+// in-memory, without shelling out to the bosh CLI or writing temp files.
+//
+// It exists so tests can assert on the semantic op tree an ops file
+// produces ("this op replaces path X", "this one appends to Y") instead of
+// string-diffing rendered manifest output.
+package opsengine
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Op is a single bosh-style ops file operation.
+type Op struct {
+	Type  string      `yaml:"type"`
+	Path  string      `yaml:"path"`
+	Value interface{} `yaml:"value,omitempty"`
+
+	// Source is the file the op was loaded from, used to annotate dry-run
+	// output. It is not part of the YAML representation of an op.
+	Source string `yaml:"-"`
+}
+
+// OpSet is an ordered stack of ops, optionally drawn from more than one
+// source file, that can be layered, bound to variables, and applied.
+type OpSet struct {
+	Ops []Op
+}
+
+// LoadOpsFile reads an ops file from disk and appends its ops to the set,
+// annotating each with path as its Source.
+func (s *OpSet) LoadOpsFile(path string) error {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading ops file %s: %v", path, err)
+	}
+
+	var ops []Op
+	if err := yaml.Unmarshal(raw, &ops); err != nil {
+		return fmt.Errorf("parsing ops file %s: %v", path, err)
+	}
+
+	for i := range ops {
+		ops[i].Source = path
+	}
+
+	s.Ops = append(s.Ops, ops...)
+	return nil
+}
+
+// Layer appends additional ops on top of the ones already in the set,
+// preserving their relative order.
+func (s *OpSet) Layer(ops ...Op) {
+	s.Ops = append(s.Ops, ops...)
+}
+
+// LoadVarsFile reads a bosh `--vars-file` style YAML document (a flat
+// mapping of variable name to value) and returns it as the same
+// map[string]string shape BindVars accepts.
+func LoadVarsFile(path string) (map[string]string, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading vars file %s: %v", path, err)
+	}
+
+	var rawVars map[string]interface{}
+	if err := yaml.Unmarshal(raw, &rawVars); err != nil {
+		return nil, fmt.Errorf("parsing vars file %s: %v", path, err)
+	}
+
+	vars := make(map[string]string, len(rawVars))
+	for name, value := range rawVars {
+		vars[name] = fmt.Sprintf("%v", value)
+	}
+	return vars, nil
+}
+
+var varToken = regexp.MustCompile(`\(\(([a-zA-Z0-9_-]+)\)\)`)
+
+// BindVars substitutes `((name))` tokens appearing in op values with the
+// bindings supplied, mirroring the `-v key=value` and `--vars-file`
+// arguments bosh interpolate accepts. Tokens with no binding are left
+// untouched.
+func (s *OpSet) BindVars(vars map[string]string) {
+	for i := range s.Ops {
+		s.Ops[i].Value = bindValue(s.Ops[i].Value, vars)
+	}
+}
+
+func bindValue(v interface{}, vars map[string]string) interface{} {
+	switch value := v.(type) {
+	case string:
+		return varToken.ReplaceAllStringFunc(value, func(tok string) string {
+			name := varToken.FindStringSubmatch(tok)[1]
+			if bound, ok := vars[name]; ok {
+				return bound
+			}
+			return tok
+		})
+	case map[interface{}]interface{}:
+		out := make(map[interface{}]interface{}, len(value))
+		for k, nested := range value {
+			out[k] = bindValue(nested, vars)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(value))
+		for i, nested := range value {
+			out[i] = bindValue(nested, vars)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// Summary is a one-line, source-annotated description of a single op,
+// suitable for --dry-run output and for assertions against the op tree.
+type Summary struct {
+	Source string
+	Type   string
+	Path   string
+}
+
+func (sm Summary) String() string {
+	source := sm.Source
+	if source == "" {
+		source = "<programmatic>"
+	}
+	return fmt.Sprintf("%s: %s %s", source, sm.Type, sm.Path)
+}
+
+// DryRun renders the composed op stack in application order, one line per
+// op, annotated with the file it came from.
+func (s *OpSet) DryRun() []Summary {
+	summaries := make([]Summary, len(s.Ops))
+	for i, op := range s.Ops {
+		summaries[i] = Summary{Source: op.Source, Type: op.Type, Path: op.Path}
+	}
+	return summaries
+}
+
+// HasOp reports whether the set contains an op of the given type acting on
+// the given path.
+func (s *OpSet) HasOp(opType, path string) bool {
+	for _, op := range s.Ops {
+		if op.Type == opType && op.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply renders the ops in order against base, a parsed YAML document
+// (typically map[interface{}]interface{}), and returns the resulting
+// document. It never touches disk.
+func (s *OpSet) Apply(base interface{}) (interface{}, error) {
+	doc := base
+	for _, op := range s.Ops {
+		var err error
+		doc, err = applyOp(doc, op)
+		if err != nil {
+			source := op.Source
+			if source == "" {
+				source = "<programmatic>"
+			}
+			return nil, fmt.Errorf("%s: %s %s: %v", source, op.Type, op.Path, err)
+		}
+	}
+	return doc, nil
+}
+
+// ApplyYAML is a convenience wrapper around Apply that takes and returns
+// raw YAML.
+func (s *OpSet) ApplyYAML(base []byte) ([]byte, error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(base, &doc); err != nil {
+		return nil, fmt.Errorf("parsing base manifest: %v", err)
+	}
+
+	result, err := s.Apply(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return yaml.Marshal(result)
+}
+
+func applyOp(doc interface{}, op Op) (interface{}, error) {
+	segments, err := parsePath(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Type {
+	case "replace":
+		return setAtPath(doc, segments, op.Value, true)
+	case "remove":
+		return setAtPath(doc, segments, nil, false)
+	case "merge":
+		existing, _ := getAtPath(doc, segments)
+		merged, err := mergeValue(existing, op.Value)
+		if err != nil {
+			return nil, err
+		}
+		return setAtPath(doc, segments, merged, true)
+	default:
+		return nil, fmt.Errorf("unsupported op type %q", op.Type)
+	}
+}
+
+// mergeValue deep-merges incoming into existing: maps are merged key by
+// key, recursing into any key present as a map on both sides, and any
+// other value (including slices) is replaced wholesale by incoming's copy.
+func mergeValue(existing, incoming interface{}) (interface{}, error) {
+	if existing == nil {
+		return incoming, nil
+	}
+
+	existingMap, existingIsMap := existing.(map[interface{}]interface{})
+	incomingMap, incomingIsMap := incoming.(map[interface{}]interface{})
+	if !existingIsMap || !incomingIsMap {
+		return nil, fmt.Errorf("merge requires both existing and incoming values to be maps")
+	}
+
+	merged := make(map[interface{}]interface{}, len(existingMap)+len(incomingMap))
+	for k, v := range existingMap {
+		merged[k] = v
+	}
+	for k, incomingVal := range incomingMap {
+		if existingVal, ok := existingMap[k]; ok {
+			mergedVal, err := mergeValue(existingVal, incomingVal)
+			if err != nil {
+				return nil, err
+			}
+			merged[k] = mergedVal
+			continue
+		}
+		merged[k] = incomingVal
+	}
+	return merged, nil
+}
+
+// pathSegment is one "/"-delimited component of a bosh-style path, e.g.
+// "name=uaa" within "/instance_groups/name=uaa/instances".
+type pathSegment struct {
+	key      string
+	selector *selector
+	optional bool // trailing "?", e.g. "providers?"
+	append   bool // trailing "-", e.g. "/variables/-"
+}
+
+type selector struct {
+	field string
+	value string
+}
+
+func parsePath(path string) ([]pathSegment, error) {
+	trimmed := strings.Trim(path, "/")
+	if trimmed == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(trimmed, "/")
+	segments := make([]pathSegment, 0, len(parts))
+	for _, part := range parts {
+		if part == "-" {
+			segments = append(segments, pathSegment{append: true})
+			continue
+		}
+
+		seg := pathSegment{key: part}
+		if strings.HasSuffix(seg.key, "?") {
+			seg.optional = true
+			seg.key = strings.TrimSuffix(seg.key, "?")
+		}
+		if eq := strings.Index(seg.key, "="); eq >= 0 {
+			seg.selector = &selector{field: seg.key[:eq], value: seg.key[eq+1:]}
+			seg.key = ""
+		}
+		segments = append(segments, seg)
+	}
+	return segments, nil
+}
+
+func getAtPath(doc interface{}, segments []pathSegment) (interface{}, bool) {
+	cur := doc
+	for _, seg := range segments {
+		next, ok := descend(cur, seg)
+		if !ok {
+			return nil, false
+		}
+		cur = next
+	}
+	return cur, true
+}
+
+func descend(cur interface{}, seg pathSegment) (interface{}, bool) {
+	if seg.selector != nil {
+		list, ok := cur.([]interface{})
+		if !ok {
+			return nil, false
+		}
+		for _, item := range list {
+			m, ok := item.(map[interface{}]interface{})
+			if !ok {
+				continue
+			}
+			if fmt.Sprintf("%v", m[seg.selector.field]) == seg.selector.value {
+				return item, true
+			}
+		}
+		return nil, false
+	}
+
+	m, ok := cur.(map[interface{}]interface{})
+	if !ok {
+		return nil, false
+	}
+	v, ok := m[seg.key]
+	return v, ok
+}
+
+// setAtPath returns a copy of doc with value set (or removed, when set is
+// false) at the location described by segments.
+func setAtPath(doc interface{}, segments []pathSegment, value interface{}, set bool) (interface{}, error) {
+	if len(segments) == 0 {
+		if set {
+			return value, nil
+		}
+		return nil, fmt.Errorf("cannot remove root document")
+	}
+
+	head, rest := segments[0], segments[1:]
+
+	if head.append {
+		list, _ := doc.([]interface{})
+		if len(rest) > 0 {
+			return nil, fmt.Errorf("append selector '-' must be the final path segment")
+		}
+		if !set {
+			return nil, fmt.Errorf("cannot remove via an append selector")
+		}
+		return append(list, value), nil
+	}
+
+	if head.selector != nil {
+		orig, _ := doc.([]interface{})
+		list := append([]interface{}{}, orig...)
+		for i, item := range list {
+			m, ok := item.(map[interface{}]interface{})
+			if !ok {
+				continue
+			}
+			if fmt.Sprintf("%v", m[head.selector.field]) != head.selector.value {
+				continue
+			}
+			if len(rest) == 0 {
+				if !set {
+					return append(list[:i:i], list[i+1:]...), nil
+				}
+				list[i] = value
+				return list, nil
+			}
+			updated, err := setAtPath(item, rest, value, set)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = updated
+			return list, nil
+		}
+		if !set {
+			if head.optional {
+				return doc, nil
+			}
+			return nil, fmt.Errorf("no element matches %s=%s", head.selector.field, head.selector.value)
+		}
+		return nil, fmt.Errorf("no element matches %s=%s", head.selector.field, head.selector.value)
+	}
+
+	m, ok := doc.(map[interface{}]interface{})
+	if !ok {
+		if doc == nil {
+			m = map[interface{}]interface{}{}
+		} else {
+			return nil, fmt.Errorf("expected a mapping at %q", head.key)
+		}
+	} else {
+		// Copy so callers retain an immutable view of the original doc.
+		copied := make(map[interface{}]interface{}, len(m))
+		for k, v := range m {
+			copied[k] = v
+		}
+		m = copied
+	}
+
+	if len(rest) == 0 {
+		if !set {
+			delete(m, head.key)
+			return m, nil
+		}
+		m[head.key] = value
+		return m, nil
+	}
+
+	child, exists := m[head.key]
+	if !exists && !set && head.optional {
+		return m, nil
+	}
+	updated, err := setAtPath(child, rest, value, set)
+	if err != nil {
+		return nil, err
+	}
+	m[head.key] = updated
+	return m, nil
+}
@@ -0,0 +1,233 @@
+package opsengine
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestApplyReplace(t *testing.T) {
+	base := map[interface{}]interface{}{
+		"instances": 2,
+	}
+
+	set := OpSet{Ops: []Op{
+		{Type: "replace", Path: "/instances", Value: 5},
+	}}
+
+	result, err := set.Apply(base)
+	if err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+
+	got := result.(map[interface{}]interface{})["instances"]
+	if got != 5 {
+		t.Errorf("expected instances to be replaced with 5, got %v", got)
+	}
+}
+
+func TestApplyRemove(t *testing.T) {
+	base := map[interface{}]interface{}{
+		"instances": 2,
+		"azs":       []interface{}{"z1", "z2"},
+	}
+
+	set := OpSet{Ops: []Op{
+		{Type: "remove", Path: "/azs"},
+	}}
+
+	result, err := set.Apply(base)
+	if err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+
+	if _, ok := result.(map[interface{}]interface{})["azs"]; ok {
+		t.Errorf("expected azs to be removed")
+	}
+}
+
+func TestApplyMerge(t *testing.T) {
+	base := map[interface{}]interface{}{
+		"properties": map[interface{}]interface{}{
+			"foo": "bar",
+		},
+	}
+
+	set := OpSet{Ops: []Op{
+		{Type: "merge", Path: "/properties", Value: map[interface{}]interface{}{
+			"baz": "qux",
+		}},
+	}}
+
+	result, err := set.Apply(base)
+	if err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+
+	props := result.(map[interface{}]interface{})["properties"].(map[interface{}]interface{})
+	if props["foo"] != "bar" {
+		t.Errorf("expected merge to preserve existing key 'foo', got %v", props["foo"])
+	}
+	if props["baz"] != "qux" {
+		t.Errorf("expected merge to add new key 'baz', got %v", props["baz"])
+	}
+}
+
+func TestApplyMergeIsRecursive(t *testing.T) {
+	base := map[interface{}]interface{}{
+		"properties": map[interface{}]interface{}{
+			"foo": map[interface{}]interface{}{
+				"a": 1,
+				"b": 2,
+			},
+		},
+	}
+
+	set := OpSet{Ops: []Op{
+		{Type: "merge", Path: "/properties", Value: map[interface{}]interface{}{
+			"foo": map[interface{}]interface{}{
+				"c": 3,
+			},
+		}},
+	}}
+
+	result, err := set.Apply(base)
+	if err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+
+	foo := result.(map[interface{}]interface{})["properties"].(map[interface{}]interface{})["foo"].(map[interface{}]interface{})
+	if foo["a"] != 1 || foo["b"] != 2 {
+		t.Errorf("expected a deep merge to preserve existing nested keys 'a' and 'b', got %v", foo)
+	}
+	if foo["c"] != 3 {
+		t.Errorf("expected a deep merge to add new nested key 'c', got %v", foo)
+	}
+}
+
+func TestApplyArraySelector(t *testing.T) {
+	base := map[interface{}]interface{}{
+		"instance_groups": []interface{}{
+			map[interface{}]interface{}{"name": "uaa", "instances": 1},
+			map[interface{}]interface{}{"name": "diego-cell", "instances": 3},
+		},
+	}
+
+	set := OpSet{Ops: []Op{
+		{Type: "replace", Path: "/instance_groups/name=diego-cell/instances", Value: 4},
+	}}
+
+	result, err := set.Apply(base)
+	if err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+
+	groups := result.(map[interface{}]interface{})["instance_groups"].([]interface{})
+	for _, g := range groups {
+		group := g.(map[interface{}]interface{})
+		if group["name"] == "diego-cell" && group["instances"] != 4 {
+			t.Errorf("expected diego-cell instances to be 4, got %v", group["instances"])
+		}
+		if group["name"] == "uaa" && group["instances"] != 1 {
+			t.Errorf("expected uaa instances to be untouched, got %v", group["instances"])
+		}
+	}
+}
+
+func TestApplyArraySelectorDoesNotMutateBase(t *testing.T) {
+	groups := []interface{}{
+		map[interface{}]interface{}{"name": "uaa", "instances": 1},
+		map[interface{}]interface{}{"name": "diego-cell", "instances": 3},
+	}
+	base := map[interface{}]interface{}{
+		"instance_groups": groups,
+	}
+
+	set := OpSet{Ops: []Op{
+		{Type: "replace", Path: "/instance_groups/name=diego-cell/instances", Value: 4},
+	}}
+
+	if _, err := set.Apply(base); err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+
+	for _, g := range groups {
+		group := g.(map[interface{}]interface{})
+		if group["name"] == "diego-cell" && group["instances"] != 3 {
+			t.Errorf("expected Apply to leave the caller's original slice untouched, got diego-cell instances %v", group["instances"])
+		}
+	}
+}
+
+func TestApplyAppend(t *testing.T) {
+	base := map[interface{}]interface{}{
+		"variables": []interface{}{
+			map[interface{}]interface{}{"name": "existing"},
+		},
+	}
+
+	set := OpSet{Ops: []Op{
+		{Type: "replace", Path: "/variables/-", Value: map[interface{}]interface{}{"name": "new_var"}},
+	}}
+
+	result, err := set.Apply(base)
+	if err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+
+	vars := result.(map[interface{}]interface{})["variables"].([]interface{})
+	if len(vars) != 2 {
+		t.Fatalf("expected 2 variables after append, got %d", len(vars))
+	}
+	if vars[1].(map[interface{}]interface{})["name"] != "new_var" {
+		t.Errorf("expected appended variable to be 'new_var', got %v", vars[1])
+	}
+}
+
+func TestBindVars(t *testing.T) {
+	set := OpSet{Ops: []Op{
+		{Type: "replace", Path: "/a", Value: "((greeting)), ((name))"},
+	}}
+
+	set.BindVars(map[string]string{"greeting": "hello", "name": "world"})
+
+	if set.Ops[0].Value != "hello, world" {
+		t.Errorf("expected vars to be bound, got %q", set.Ops[0].Value)
+	}
+}
+
+func TestLoadVarsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vars.yml")
+	contents := "greeting: hello\nname: world\n"
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("failed to write vars file: %v", err)
+	}
+
+	vars, err := LoadVarsFile(path)
+	if err != nil {
+		t.Fatalf("LoadVarsFile returned error: %v", err)
+	}
+
+	want := map[string]string{"greeting": "hello", "name": "world"}
+	if !reflect.DeepEqual(vars, want) {
+		t.Errorf("LoadVarsFile() = %v, want %v", vars, want)
+	}
+}
+
+func TestDryRunAnnotatesSource(t *testing.T) {
+	set := OpSet{Ops: []Op{
+		{Type: "replace", Path: "/a", Source: "example.yml"},
+		{Type: "remove", Path: "/b"},
+	}}
+
+	got := set.DryRun()
+	want := []Summary{
+		{Source: "example.yml", Type: "replace", Path: "/a"},
+		{Source: "", Type: "remove", Path: "/b"},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DryRun() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,211 @@
+// Package schema models the pieces of cf-deployment.yml that the semantic
+// test suite validates: instance groups, jobs, the job properties those
+// tests actually assert on, releases, and variables. It exists so new
+// ops-file tests can add assertions without redeclaring YAML tags for the
+// same manifest fragments over and over.
+package schema
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Manifest is the subset of a cf-deployment manifest the semantic test
+// suite cares about.
+type Manifest struct {
+	InstanceGroups []InstanceGroup `yaml:"instance_groups"`
+	Releases       []Release
+	Variables      []Variable
+}
+
+// InstanceGroup finds the instance group with the given name, or nil if
+// the manifest has none.
+func (m Manifest) InstanceGroup(name string) *InstanceGroup {
+	for i := range m.InstanceGroups {
+		if m.InstanceGroups[i].Name == name {
+			return &m.InstanceGroups[i]
+		}
+	}
+	return nil
+}
+
+// Variable finds the declared variable with the given name, or nil if the
+// manifest has none.
+func (m Manifest) Variable(name string) *Variable {
+	for i := range m.Variables {
+		if m.Variables[i].Name == name {
+			return &m.Variables[i]
+		}
+	}
+	return nil
+}
+
+type InstanceGroup struct {
+	Name      string
+	Instances *int
+	AZs       []string
+	Networks  []Network
+	Jobs      []Job
+}
+
+// Job finds the job with the given name on this instance group, or nil if
+// it has none.
+func (ig InstanceGroup) Job(name string) *Job {
+	for i := range ig.Jobs {
+		if ig.Jobs[i].Name == name {
+			return &ig.Jobs[i]
+		}
+	}
+	return nil
+}
+
+type Network struct {
+	Name string
+}
+
+type Job struct {
+	Name       string
+	Release    string
+	Properties Properties
+}
+
+// Properties covers only the property trees the semantic test suite
+// asserts on today: doppler, diego.rep, uaa, uaa's login/oauth providers,
+// and uaadb. Add fields here as new ops-file tests need them.
+type Properties struct {
+	Doppler DopplerProperties
+	Diego   DiegoProperties
+	Uaa     UaaProperties
+	Login   LoginProperties
+	Uaadb   UaadbProperties
+}
+
+type DopplerProperties struct {
+	Port *int
+}
+
+type DiegoProperties struct {
+	Rep RepProperties
+}
+
+type RepProperties struct {
+	PlacementTags              []string `yaml:"placement_tags"`
+	PersistentIsolationSegment *bool    `yaml:"persistent_isolation_segment"`
+}
+
+type UaaProperties struct {
+	URL string `yaml:"url"`
+}
+
+type LoginProperties struct {
+	Oauth OauthProperties
+}
+
+type OauthProperties struct {
+	Providers map[string]OauthProvider
+}
+
+type OauthProvider struct {
+	Type               string `yaml:"type"`
+	DiscoveryURL       string `yaml:"discoveryUrl"`
+	RelyingPartyID     string `yaml:"relyingPartyId"`
+	RelyingPartySecret string `yaml:"relyingPartySecret"`
+	Scopes             []string
+	AttributeMappings  map[string]string `yaml:"attributeMappings"`
+}
+
+type UaadbProperties struct {
+	Databases []UaadbDatabase
+	Roles     []UaadbRole
+}
+
+type UaadbDatabase struct {
+	Tag  string
+	Name string
+}
+
+type UaadbRole struct {
+	Tag      string
+	Name     string
+	Password string
+}
+
+type Release struct {
+	Name string
+	URL  string
+}
+
+type Variable struct {
+	Name string
+	Type string
+}
+
+// AssertJobProperty fails t unless the named job on the named instance
+// group has its property at the given dotted bosh path (e.g.
+// "diego.rep.placement_tags") equal to expected.
+func AssertJobProperty(t *testing.T, m Manifest, instanceGroup, job, path string, expected interface{}) {
+	t.Helper()
+
+	ig := m.InstanceGroup(instanceGroup)
+	if ig == nil {
+		t.Errorf("expected instance group %q to exist", instanceGroup)
+		return
+	}
+
+	j := ig.Job(job)
+	if j == nil {
+		t.Errorf("expected instance group %q to have job %q", instanceGroup, job)
+		return
+	}
+
+	raw, err := yaml.Marshal(j.Properties)
+	if err != nil {
+		t.Errorf("failed to marshal properties for %s/%s: %v", instanceGroup, job, err)
+		return
+	}
+
+	var tree interface{}
+	if err := yaml.Unmarshal(raw, &tree); err != nil {
+		t.Errorf("failed to unmarshal properties for %s/%s: %v", instanceGroup, job, err)
+		return
+	}
+
+	got, ok := lookupPath(tree, path)
+	if !ok {
+		t.Errorf("%s/%s has no property at %q", instanceGroup, job, path)
+		return
+	}
+
+	gotYAML, err := yaml.Marshal(got)
+	if err != nil {
+		t.Errorf("failed to marshal actual value at %s/%s %q: %v", instanceGroup, job, path, err)
+		return
+	}
+	wantYAML, err := yaml.Marshal(expected)
+	if err != nil {
+		t.Errorf("failed to marshal expected value at %s/%s %q: %v", instanceGroup, job, path, err)
+		return
+	}
+
+	if string(gotYAML) != string(wantYAML) {
+		t.Errorf("%s/%s property %q = %v, want %v", instanceGroup, job, path, got, expected)
+	}
+}
+
+func lookupPath(tree interface{}, path string) (interface{}, bool) {
+	cur := tree
+	for _, part := range strings.Split(path, ".") {
+		m, ok := cur.(map[interface{}]interface{})
+		if !ok {
+			return nil, false
+		}
+		v, ok := m[part]
+		if !ok {
+			return nil, false
+		}
+		cur = v
+	}
+	return cur, true
+}
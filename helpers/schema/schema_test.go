@@ -0,0 +1,110 @@
+package schema
+
+import "testing"
+
+func TestInstanceGroupAndJobLookup(t *testing.T) {
+	m := Manifest{
+		InstanceGroups: []InstanceGroup{
+			{Name: "uaa", Jobs: []Job{{Name: "uaa"}}},
+		},
+	}
+
+	ig := m.InstanceGroup("uaa")
+	if ig == nil {
+		t.Fatalf("expected to find instance group 'uaa'")
+	}
+	if ig.Job("uaa") == nil {
+		t.Errorf("expected to find job 'uaa' on instance group 'uaa'")
+	}
+	if ig.Job("missing") != nil {
+		t.Errorf("expected no job named 'missing'")
+	}
+	if m.InstanceGroup("missing") != nil {
+		t.Errorf("expected no instance group named 'missing'")
+	}
+}
+
+func TestVariableLookup(t *testing.T) {
+	m := Manifest{
+		Variables: []Variable{
+			{Name: "uaa_oidc_database_password", Type: "password"},
+		},
+	}
+
+	v := m.Variable("uaa_oidc_database_password")
+	if v == nil || v.Type != "password" {
+		t.Errorf("expected to find 'uaa_oidc_database_password' declared as a password variable, got %v", v)
+	}
+	if m.Variable("missing") != nil {
+		t.Errorf("expected no variable named 'missing'")
+	}
+}
+
+func TestLookupPath(t *testing.T) {
+	tree := map[interface{}]interface{}{
+		"diego": map[interface{}]interface{}{
+			"rep": map[interface{}]interface{}{
+				"placement_tags": []interface{}{"iso-seg-1"},
+			},
+		},
+	}
+
+	got, ok := lookupPath(tree, "diego.rep.placement_tags")
+	if !ok {
+		t.Fatalf("expected to find diego.rep.placement_tags")
+	}
+	tags, ok := got.([]interface{})
+	if !ok || len(tags) != 1 || tags[0] != "iso-seg-1" {
+		t.Errorf("expected placement_tags to be [iso-seg-1], got %v", got)
+	}
+
+	if _, ok := lookupPath(tree, "diego.rep.missing"); ok {
+		t.Errorf("expected lookup of a missing path to fail")
+	}
+}
+
+func TestAssertJobProperty(t *testing.T) {
+	m := Manifest{
+		InstanceGroups: []InstanceGroup{
+			{
+				Name: "isolated-diego-cell",
+				Jobs: []Job{
+					{
+						Name: "rep",
+						Properties: Properties{
+							Diego: DiegoProperties{
+								Rep: RepProperties{
+									PlacementTags: []string{"iso-seg-1"},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	t.Run("matching value passes", func(t *testing.T) {
+		spy := &testing.T{}
+		AssertJobProperty(spy, m, "isolated-diego-cell", "rep", "diego.rep.placement_tags", []string{"iso-seg-1"})
+		if spy.Failed() {
+			t.Errorf("expected AssertJobProperty to pass for a matching value")
+		}
+	})
+
+	t.Run("mismatched value fails", func(t *testing.T) {
+		spy := &testing.T{}
+		AssertJobProperty(spy, m, "isolated-diego-cell", "rep", "diego.rep.placement_tags", []string{"other-seg"})
+		if !spy.Failed() {
+			t.Errorf("expected AssertJobProperty to fail for a mismatched value")
+		}
+	})
+
+	t.Run("missing instance group fails", func(t *testing.T) {
+		spy := &testing.T{}
+		AssertJobProperty(spy, m, "missing", "rep", "diego.rep.placement_tags", []string{"iso-seg-1"})
+		if !spy.Failed() {
+			t.Errorf("expected AssertJobProperty to fail for a missing instance group")
+		}
+	})
+}
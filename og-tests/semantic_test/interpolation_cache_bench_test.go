@@ -0,0 +1,45 @@
+package standard_test
+
+import (
+	"og/helpers"
+	"path/filepath"
+	"testing"
+)
+
+// BenchmarkInterpolationCache demonstrates the win a shared interpolation
+// cache gives table entries that repeatedly ask about the same (opsFiles,
+// vars) combination: the warm path reuses the already-unmarshalled
+// manifest instead of re-shelling bosh.
+func BenchmarkInterpolationCache(b *testing.B) {
+	cfDeploymentHome, err := helpers.SetPath()
+	if err != nil {
+		b.Fatalf("setup: %v", err)
+	}
+
+	operationsSubDirectory := filepath.Join(cfDeploymentHome, "operations")
+	manifestPath := filepath.Join(cfDeploymentHome, "cf-deployment.yml")
+	opsFiles := []string{"aws.yml"}
+
+	b.Run("cold", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			cache := newInterpolationCache(operationsSubDirectory, manifestPath)
+			if _, err := cache.interpolate(opsFiles, nil); err != nil {
+				b.Fatalf("interpolate: %v", err)
+			}
+		}
+	})
+
+	b.Run("warm", func(b *testing.B) {
+		cache := newInterpolationCache(operationsSubDirectory, manifestPath)
+		if _, err := cache.interpolate(opsFiles, nil); err != nil {
+			b.Fatalf("interpolate: %v", err)
+		}
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := cache.interpolate(opsFiles, nil); err != nil {
+				b.Fatalf("interpolate: %v", err)
+			}
+		}
+	})
+}
@@ -0,0 +1,87 @@
+package standard_test
+
+import (
+	"fmt"
+	"og/helpers"
+	"og/helpers/schema"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+// interpolationCache shells out to `bosh interpolate` at most once per
+// distinct (opsFiles, vars) combination, so table entries that happen to
+// reuse the same ops stack and variable bindings don't re-pay the cost of
+// spawning bosh.
+type interpolationCache struct {
+	operationsSubDirectory string
+	manifestPath           string
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+type cacheEntry struct {
+	once     sync.Once
+	manifest schema.Manifest
+	err      error
+}
+
+func newInterpolationCache(operationsSubDirectory, manifestPath string) *interpolationCache {
+	return &interpolationCache{
+		operationsSubDirectory: operationsSubDirectory,
+		manifestPath:           manifestPath,
+		entries:                make(map[string]*cacheEntry),
+	}
+}
+
+func (c *interpolationCache) interpolate(opsFiles []string, vars map[string]string) (schema.Manifest, error) {
+	key := cacheKey(opsFiles, vars)
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &cacheEntry{}
+		c.entries[key] = entry
+	}
+	c.mu.Unlock()
+
+	entry.once.Do(func() {
+		args := make([]string, 0, len(opsFiles)*2+len(vars)*2)
+		for _, opsFile := range opsFiles {
+			args = append(args, "-o", opsFile)
+		}
+		for name, value := range vars {
+			args = append(args, "-v", fmt.Sprintf("%s=%s", name, value))
+		}
+
+		raw, err := helpers.BoshInterpolate(c.operationsSubDirectory, c.manifestPath, "", args...)
+		if err != nil {
+			entry.err = fmt.Errorf("bosh interpolate error: %v", err)
+			return
+		}
+
+		if err := yaml.Unmarshal(raw, &entry.manifest); err != nil {
+			entry.err = fmt.Errorf("failed to unmarshal bosh interpolate output: %v", err)
+		}
+	})
+
+	return entry.manifest, entry.err
+}
+
+func cacheKey(opsFiles []string, vars map[string]string) string {
+	varNames := make([]string, 0, len(vars))
+	for name := range vars {
+		varNames = append(varNames, name)
+	}
+	sort.Strings(varNames)
+
+	var key strings.Builder
+	key.WriteString(strings.Join(opsFiles, ","))
+	for _, name := range varNames {
+		fmt.Fprintf(&key, ";%s=%s", name, vars[name])
+	}
+	return key.String()
+}
@@ -1,41 +1,26 @@
 package standard_test
 
 import (
-	"fmt"
 	"og/helpers"
+	"og/helpers/manifestdiff"
+	"og/helpers/opsengine"
+	"og/helpers/schema"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"testing"
 
-	"github.com/sergi/go-diff/diffmatchpatch"
 	"gopkg.in/yaml.v2"
 )
 
-type instanceGroup struct {
-	Name      string
-	Instances *int
-	AZs       []string
-	Networks  []struct {
-		Name string
-	}
-	Jobs []struct {
-		Properties struct {
-			Doppler struct {
-				Port *int
-			}
-		}
-	}
-}
-
-type releases struct {
-	Name string
-	URL  string
-}
-
-type manifest struct {
-	InstanceGroups []instanceGroup `yaml:"instance_groups"`
-	Releases       []releases
+// semanticTestCase is one table entry: an ops file (or stack of ops files)
+// interpolated with a fixed set of variables, followed by a list of
+// assertions run against the resulting manifest.
+type semanticTestCase struct {
+	name       string
+	opsFiles   []string
+	vars       map[string]string
+	assertions []func(t *testing.T, m schema.Manifest)
 }
 
 func TestSemantic(t *testing.T) {
@@ -46,133 +31,150 @@ func TestSemantic(t *testing.T) {
 
 	operationsSubDirectory := filepath.Join(cfDeploymentHome, "operations")
 	manifestPath := filepath.Join(cfDeploymentHome, "cf-deployment.yml")
+	cache := newInterpolationCache(operationsSubDirectory, manifestPath)
+
+	cases := []semanticTestCase{
+		{
+			name:     "rename-network-and-deployment.yml",
+			opsFiles: []string{"rename-network-and-deployment.yml"},
+			vars: map[string]string{
+				"network_name":    "test_network",
+				"deployment_name": "test_deployment",
+			},
+			assertions: []func(t *testing.T, m schema.Manifest){
+				assertSingleRenamedNetwork("test_network"),
+			},
+		},
+		{
+			name:     "aws.yml",
+			opsFiles: []string{"aws.yml"},
+			assertions: []func(t *testing.T, m schema.Manifest){
+				assertDopplerPort(4443),
+			},
+		},
+		{
+			name:     "scale-to-one-az.yml",
+			opsFiles: []string{"scale-to-one-az.yml"},
+			assertions: []func(t *testing.T, m schema.Manifest){
+				assertScaledToSingleAZ("z1"),
+			},
+		},
+		{
+			name:     "use-compiled-releases.yml",
+			opsFiles: []string{"use-compiled-releases.yml"},
+			assertions: []func(t *testing.T, m schema.Manifest){
+				assertReleasesAreCompiled,
+			},
+		},
+		{
+			name:     "add-oidc-provider.yml",
+			opsFiles: []string{"add-oidc-provider.yml"},
+			vars: map[string]string{
+				"oidc_discovery_url":        "https://idp.example.com/.well-known/openid-configuration",
+				"oidc_relying_party_id":     "cf-uaa",
+				"oidc_relying_party_secret": "some-secret",
+			},
+			assertions: []func(t *testing.T, m schema.Manifest){
+				assertOidcProvider("uaa"),
+				assertVariableDeclared("oidc_relying_party_secret", "password"),
+			},
+		},
+		{
+			name:     "experimental/add-oidc-provider.yml",
+			opsFiles: []string{"add-oidc-provider.yml", "experimental/add-oidc-provider.yml"},
+			vars: map[string]string{
+				"oidc_discovery_url":                "https://idp.example.com/.well-known/openid-configuration",
+				"oidc_relying_party_id":             "cf-uaa",
+				"oidc_relying_party_secret":         "some-secret",
+				"uaa_oidc_database_password":        "some-password",
+				"uaa_oidc_db_encryption_passphrase": "some-passphrase",
+			},
+			assertions: []func(t *testing.T, m schema.Manifest){
+				assertOidcProvider("uaa-oidc"),
+				assertDistinctUaadb("uaa", "uaa-oidc"),
+				assertVariableDeclared("uaa_oidc_database_password", "password"),
+				assertVariableDeclared("uaa_oidc_db_encryption_passphrase", "password"),
+			},
+		},
+	}
 
-	t.Run("rename-network-and-deployment.yml", func(t *testing.T) {
-		expectedNetworkName := "test_network"
-
-		manifest, err := boshInterpolateAndUnmarshal(
-			operationsSubDirectory,
-			manifestPath,
-			"-o", "rename-network-and-deployment.yml",
-			"-v", fmt.Sprintf("network_name=%s", expectedNetworkName),
-			"-v", "deployment_name=test_deployment",
-		)
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
 
-		if err != nil {
-			t.Errorf("failed to get unmarshalled manifest: %v", err)
-		}
-
-		for _, ig := range manifest.InstanceGroups {
-			if len(ig.Networks) != 1 {
-				t.Errorf("instance group '%s' should only have 1 network", ig.Name)
-			}
-
-			networkName := ig.Networks[0].Name
-			if networkName != expectedNetworkName {
-				t.Errorf("network name '%s' on instance '%s' does not match expected network name '%s'", networkName, ig.Name, expectedNetworkName)
+			m, err := cache.interpolate(tc.opsFiles, tc.vars)
+			if err != nil {
+				t.Fatalf("%v", err)
 			}
-		}
-	})
-
-	t.Run("aws.yml", func(t *testing.T) {
-		manifest, err := boshInterpolateAndUnmarshal(
-			operationsSubDirectory,
-			manifestPath,
-			"-o", "aws.yml",
-		)
-
-		if err != nil {
-			t.Errorf("failed to get unmarshalled manifest: %v", err)
-		}
-
-		for _, ig := range manifest.InstanceGroups {
-			for _, j := range ig.Jobs {
-				portNumber := j.Properties.Doppler.Port
 
-				if portNumber != nil && *portNumber != 4443 {
-					t.Errorf("port number '%v' on instance '%s' does not match expected port number '%v'", portNumber, ig.Name, 4443)
-				}
+			for _, assertion := range tc.assertions {
+				assertion(t, m)
 			}
-		}
-	})
-
-	t.Run("scale-to-one-az.yml", func(t *testing.T) {
-		manifest, err := boshInterpolateAndUnmarshal(
-			operationsSubDirectory,
-			manifestPath,
-			"-o", "scale-to-one-az.yml",
-		)
-
-		if err != nil {
-			t.Errorf("failed to get unmarshalled manifest: %v", err)
-		}
+		})
+	}
 
-		for _, ig := range manifest.InstanceGroups {
-			if ig.Instances != nil && *ig.Instances != 1 {
-				t.Errorf("%s has %d instances but expected to have 1", ig.Name, *ig.Instances)
-			}
-			if len(ig.AZs) != 1 || ig.AZs[0] != "z1" {
-				t.Errorf("%s should have single AZ named 'z1'", ig.Name)
-			}
-		}
-	})
+	t.Run("add-oidc-provider.yml/generated-vars-satisfy-var-errs", func(t *testing.T) {
+		t.Parallel()
 
-	t.Run("use-compiled-releases.yml", func(t *testing.T) {
-		manifest, err := boshInterpolateAndUnmarshal(
+		// oidc_discovery_url and oidc_relying_party_id identify the external
+		// IdP and must be supplied by the operator, but oidc_relying_party_secret,
+		// uaa_oidc_database_password and uaa_oidc_db_encryption_passphrase are
+		// all declared under /variables as generated passwords, so --var-errs
+		// must succeed without them being passed via -v.
+		_, err := helpers.BoshInterpolate(
 			operationsSubDirectory,
 			manifestPath,
-			"-o", "use-compiled-releases.yml",
+			"",
+			"-o", "add-oidc-provider.yml",
+			"-o", "experimental/add-oidc-provider.yml",
+			"-v", "oidc_discovery_url=https://idp.example.com/.well-known/openid-configuration",
+			"-v", "oidc_relying_party_id=cf-uaa",
+			"--var-errs",
 		)
 
 		if err != nil {
-			t.Errorf("failed to get unmarshalled manifest: %v", err)
-		}
-
-		for _, r := range manifest.Releases {
-			re, err := regexp.Compile(`github\.com|bosh\.com`)
-			if err != nil {
-				t.Errorf("regexp compile error: %v", err)
-				t.Error(err)
-			}
-
-			if re.MatchString(r.URL) {
-				t.Errorf("expected release %s to be compiled, but got the release from %s", r.Name, r.URL)
-			}
+			t.Errorf("expected bosh interpolate --var-errs to succeed using the /variables-declared defaults for oidc_relying_party_secret, uaa_oidc_database_password and uaa_oidc_db_encryption_passphrase, got: %v", err)
 		}
 	})
 
 	t.Run("use-trusted-ca-cert-for-apps.yml", func(t *testing.T) {
-		certsPath := "/instance_groups/name=diego-cell/jobs/name=cflinuxfs2-rootfs-setup/properties/cflinuxfs2-rootfs/trusted_certs"
-
-		existingCA, err := helpers.BoshInterpolate(
-			operationsSubDirectory,
-			manifestPath,
-			"",
-			"--path", certsPath,
-		)
-
-		if err != nil {
-			t.Errorf("bosh interpolate error: %v", err)
+		t.Parallel()
+
+		existingCert := "-----BEGIN CERTIFICATE-----\nexisting-trusted-cert\n-----END CERTIFICATE-----\n"
+		base := []byte(`
+instance_groups:
+- name: diego-cell
+  jobs:
+  - name: cflinuxfs2-rootfs-setup
+    properties:
+      cflinuxfs2-rootfs:
+        trusted_certs: |
+          ` + existingCert)
+
+		var ops opsengine.OpSet
+		if err := ops.LoadOpsFile(filepath.Join(operationsSubDirectory, "use-trusted-ca-cert-for-apps.yml")); err != nil {
+			t.Fatalf("failed to load use-trusted-ca-cert-for-apps.yml: %v", err)
 		}
 
-		newCA, err := helpers.BoshInterpolate(
-			operationsSubDirectory,
-			manifestPath,
-			"",
-			"--path", certsPath,
-			"-o", "use-trusted-ca-cert-for-apps.yml",
-		)
+		for _, summary := range ops.DryRun() {
+			t.Logf("%s", summary)
+		}
 
+		result, err := ops.ApplyYAML(base)
 		if err != nil {
-			t.Errorf("bosh interpolate error: %v", err)
+			t.Fatalf("failed to apply use-trusted-ca-cert-for-apps.yml to a base with existing trusted_certs: %v", err)
 		}
 
-		if existingCA, newCA := formatCAs(existingCA, newCA); strings.Contains(existingCA, newCA) {
-			t.Errorf("use-trusted-ca-cert-for-apps.yml overwrites existing trusted CAs from cf-deployment.yml.\nTrusted CAs before applying the ops file:\n\n%s\n\nTrusted CAs after applying the ops file:\n\n%s", existingCA, newCA)
+		if !strings.Contains(string(result), "existing-trusted-cert") {
+			t.Errorf("expected applying use-trusted-ca-cert-for-apps.yml to preserve the cflinuxfs2-rootfs job's existing trusted_certs, got:\n%s", result)
 		}
 	})
 
 	t.Run("add-persistent-isolation-segment-diego-cell.yml", func(t *testing.T) {
+		t.Parallel()
+
+		allowedDivergence := []string{"placement_tags", "diego.rep.persistent_isolation_segment"}
 
 		diegoCellRepProperties, err := helpers.BoshInterpolate(
 			operationsSubDirectory,
@@ -197,51 +199,165 @@ func TestSemantic(t *testing.T) {
 			t.Errorf("bosh interpolate error: %v", err)
 		}
 
-		dmp := diffmatchpatch.New()
+		var before, after interface{}
+		if err := yaml.Unmarshal(diegoCellRepProperties, &before); err != nil {
+			t.Fatalf("failed to unmarshal diego-cell rep properties: %v", err)
+		}
+		if err := yaml.Unmarshal(isoSegDiegoCellRepProperties, &after); err != nil {
+			t.Fatalf("failed to unmarshal isolated-diego-cell rep properties: %v", err)
+		}
 
-		diffs := dmp.DiffMain(
-			string(diegoCellRepProperties),
-			string(isoSegDiegoCellRepProperties),
-			false,
-		)
+		diff := manifestdiff.Compare(before, after, allowedDivergence)
 
-		fmt.Println(dmp.DiffPrettyText(diffs))
+		if diff.Unexpected != nil {
+			t.Errorf("rep properties on diego-cell have diverged from isolated-diego-cell beyond %v: %v", allowedDivergence, diff.Unexpected)
+		}
 
-		// local iso_seg_diego_cell_rep_properties=$(bosh int cf-deployment.yml -o operations/test/add-persistent-isolation-segment-diego-cell.yml \
-		//   --path /instance_groups/name=isolated-diego-cell/jobs/name=rep/properties
-		// | grep -v placement_tags | grep -v persistent_isolation_segment)
+		m, err := cache.interpolate([]string{"test/add-persistent-isolation-segment-diego-cell.yml"}, nil)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
 
-		//   diff <(echo "$diego_cell_rep_properties") <(echo "$iso_seg_diego_cell_rep_properties")
-		//   local rep_diff_exit_code=$?
+		schema.AssertJobProperty(t, m, "isolated-diego-cell", "rep", "diego.rep.placement_tags", []string{"iso-seg-1"})
+	})
+}
 
-		// if [[ $rep_diff_exit_code != 0 ]]; then
-		//   fail "rep properties on diego-cell have diverged between cf-deployment.yml and test/add-persistent-isolation-segment-diego-cell.yml"
-		// else
-		//   pass "test/add-persistent-isolation-segment-diego-cell.yml is consistent with cf-deployment.yml"
+func assertSingleRenamedNetwork(expectedNetworkName string) func(t *testing.T, m schema.Manifest) {
+	return func(t *testing.T, m schema.Manifest) {
+		for _, ig := range m.InstanceGroups {
+			if len(ig.Networks) != 1 {
+				t.Errorf("instance group '%s' should only have 1 network", ig.Name)
+				continue
+			}
 
-	})
+			networkName := ig.Networks[0].Name
+			if networkName != expectedNetworkName {
+				t.Errorf("network name '%s' on instance '%s' does not match expected network name '%s'", networkName, ig.Name, expectedNetworkName)
+			}
+		}
+	}
+}
+
+func assertDopplerPort(expectedPort int) func(t *testing.T, m schema.Manifest) {
+	return func(t *testing.T, m schema.Manifest) {
+		for _, ig := range m.InstanceGroups {
+			for _, j := range ig.Jobs {
+				portNumber := j.Properties.Doppler.Port
+
+				if portNumber != nil && *portNumber != expectedPort {
+					t.Errorf("port number '%v' on instance '%s' does not match expected port number '%v'", portNumber, ig.Name, expectedPort)
+				}
+			}
+		}
+	}
+}
+
+func assertScaledToSingleAZ(expectedAZ string) func(t *testing.T, m schema.Manifest) {
+	return func(t *testing.T, m schema.Manifest) {
+		for _, ig := range m.InstanceGroups {
+			if ig.Instances != nil && *ig.Instances != 1 {
+				t.Errorf("%s has %d instances but expected to have 1", ig.Name, *ig.Instances)
+			}
+			if len(ig.AZs) != 1 || ig.AZs[0] != expectedAZ {
+				t.Errorf("%s should have single AZ named '%s'", ig.Name, expectedAZ)
+			}
+		}
+	}
+}
 
+func assertVariableDeclared(name, expectedType string) func(t *testing.T, m schema.Manifest) {
+	return func(t *testing.T, m schema.Manifest) {
+		v := m.Variable(name)
+		if v == nil {
+			t.Errorf("expected manifest to declare a '%s' variable under /variables", name)
+			return
+		}
+		if v.Type != expectedType {
+			t.Errorf("expected variable '%s' to be of type '%s', got '%s'", name, expectedType, v.Type)
+		}
+	}
 }
 
-func formatCAs(existingRaw, newRaw []byte) (string, string) {
-	existingCAFmt := strings.TrimSpace(string(existingRaw))
-	newCAFmt := strings.TrimSpace(string(newRaw))
-	return existingCAFmt, newCAFmt
+var compiledReleaseSource = regexp.MustCompile(`github\.com|bosh\.com`)
 
+func assertReleasesAreCompiled(t *testing.T, m schema.Manifest) {
+	for _, r := range m.Releases {
+		if compiledReleaseSource.MatchString(r.URL) {
+			t.Errorf("expected release %s to be compiled, but got the release from %s", r.Name, r.URL)
+		}
+	}
 }
 
-func boshInterpolateAndUnmarshal(opsSubDir, manifestPath string, args ...string) (manifest, error) {
-	boshInterpolateOutput, err := helpers.BoshInterpolate(opsSubDir, manifestPath, "", args...)
+func assertOidcProvider(instanceGroupName string) func(t *testing.T, m schema.Manifest) {
+	return func(t *testing.T, m schema.Manifest) {
+		ig := m.InstanceGroup(instanceGroupName)
+		if ig == nil {
+			t.Fatalf("expected manifest to have a '%s' instance group", instanceGroupName)
+		}
 
-	if err != nil {
-		return manifest{}, fmt.Errorf("bosh interpolate error: %v", err)
+		var provider *schema.OauthProvider
+		for _, job := range ig.Jobs {
+			if p, ok := job.Properties.Login.Oauth.Providers["oidc-provider"]; ok {
+				provider = &p
+				break
+			}
+		}
+		if provider == nil {
+			t.Fatalf("expected '%s' to declare an 'oidc-provider' oauth provider", instanceGroupName)
+		}
+
+		if provider.DiscoveryURL == "" {
+			t.Errorf("expected oidc-provider to have a discoveryUrl")
+		}
+		if provider.RelyingPartyID == "" {
+			t.Errorf("expected oidc-provider to have a relyingPartyId")
+		}
+		if provider.RelyingPartySecret == "" {
+			t.Errorf("expected oidc-provider to have a relyingPartySecret")
+		}
+		if len(provider.Scopes) == 0 {
+			t.Errorf("expected oidc-provider to declare scopes")
+		}
+		if len(provider.AttributeMappings) == 0 {
+			t.Errorf("expected oidc-provider to declare attributeMappings")
+		}
+
+		if instanceGroupName != "uaa" {
+			if ig.Instances == nil || *ig.Instances < 1 {
+				t.Errorf("expected '%s' to have at least 1 instance", instanceGroupName)
+			}
+			if len(ig.AZs) == 0 {
+				t.Errorf("expected '%s' to declare AZs", instanceGroupName)
+			}
+		}
 	}
+}
 
-	var m manifest
-	err = yaml.Unmarshal(boshInterpolateOutput, &m)
-	if err != nil {
-		return manifest{}, fmt.Errorf("failed to unmarshal bosh interpolate output: %v", err)
+func assertDistinctUaadb(baseInstanceGroup, newInstanceGroup string) func(t *testing.T, m schema.Manifest) {
+	return func(t *testing.T, m schema.Manifest) {
+		base := findUaadb(m, baseInstanceGroup)
+		added := findUaadb(m, newInstanceGroup)
+		if base == nil || added == nil {
+			t.Fatalf("expected both '%s' and '%s' to declare a uaadb", baseInstanceGroup, newInstanceGroup)
+		}
+		if len(added.Databases) == 0 {
+			t.Fatalf("expected '%s' uaadb to declare a database", newInstanceGroup)
+		}
+		if base.Databases[0].Name == added.Databases[0].Name {
+			t.Errorf("expected '%s' to use a distinct uaadb database name from '%s', both were '%s'", newInstanceGroup, baseInstanceGroup, added.Databases[0].Name)
+		}
 	}
+}
 
-	return m, nil
+func findUaadb(m schema.Manifest, instanceGroupName string) *schema.UaadbProperties {
+	ig := m.InstanceGroup(instanceGroupName)
+	if ig == nil {
+		return nil
+	}
+	for _, job := range ig.Jobs {
+		if len(job.Properties.Uaadb.Databases) > 0 {
+			return &job.Properties.Uaadb
+		}
+	}
+	return nil
 }